@@ -1,9 +1,18 @@
 package provider
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/distribution/reference"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
 )
 
 const (
@@ -27,6 +36,8 @@ func resourceDockerImage() *schema.Resource {
 			Delete: schema.DefaultTimeout(dockerImageDeleteDefaultTimeout),
 		},
 
+		CustomizeDiff: resourceDockerImageCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"docker_client": dockerSchema,
 			"id": {
@@ -133,6 +144,30 @@ func resourceDockerImage() *schema.Resource {
 								},
 							},
 						},
+						"ssh": {
+							Type:        schema.TypeList,
+							Description: "Set SSH agent/key forwarding, mirroring buildx's `--ssh` flag. Only available when you use a buildx builder.",
+							Optional:    true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"id": {
+										Type:        schema.TypeString,
+										Description: "ID of the SSH agent or key, referenced from `RUN --mount=type=ssh,id=<id>`.",
+										Required:    true,
+										ForceNew:    true,
+									},
+									"paths": {
+										Type:        schema.TypeList,
+										Description: "Paths to SSH private keys. If omitted, the default SSH agent socket (`$SSH_AUTH_SOCK`) is forwarded instead.",
+										Optional:    true,
+										ForceNew:    true,
+										Elem: &schema.Schema{
+											Type: schema.TypeString,
+										},
+									},
+								},
+							},
+						},
 						"label": {
 							Type:        schema.TypeMap,
 							Description: "Set metadata for an image",
@@ -339,6 +374,34 @@ func resourceDockerImage() *schema.Resource {
 								Description: "The key/value pair",
 							},
 						},
+						"annotations": {
+							Type:        schema.TypeList,
+							Description: "Set OCI annotations on the build result, distinct from the image labels set via `label`/`labels`. Required for correct `org.opencontainers.image.*` metadata when pushing via `output` with `type=registry`, or on multi-platform index entries. The `level` qualifier is only accepted when `builder` is set; the legacy builder rejects it.",
+							Optional:    true,
+							ForceNew:    true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"key": {
+										Type:        schema.TypeString,
+										Description: "Annotation key.",
+										Required:    true,
+										ForceNew:    true,
+									},
+									"value": {
+										Type:        schema.TypeString,
+										Description: "Annotation value.",
+										Required:    true,
+										ForceNew:    true,
+									},
+									"level": {
+										Type:        schema.TypeString,
+										Description: "Descriptor the annotation is attached to: `manifest`, `manifest-descriptor`, `index` or `index-descriptor`. Defaults to `manifest`. Only available when `builder` is set.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+								},
+							},
+						},
 						"squash": {
 							Type:        schema.TypeBool,
 							Description: "If true the new layers are squashed into a new image with a single new layer",
@@ -347,7 +410,7 @@ func resourceDockerImage() *schema.Resource {
 						},
 						"cache_from": {
 							Type:        schema.TypeList,
-							Description: "Images to consider as cache sources",
+							Description: "Images to consider as cache sources. When `builder` is set, entries may also use the buildx `--cache-from` syntax (e.g. `type=gha` or `type=registry,ref=...`) in addition to plain image references; the legacy builder only accepts plain image references.",
 							Optional:    true,
 							ForceNew:    true,
 							Elem: &schema.Schema{
@@ -355,6 +418,16 @@ func resourceDockerImage() *schema.Resource {
 								Description: "The image",
 							},
 						},
+						"cache_to": {
+							Type:        schema.TypeList,
+							Description: "Cache export targets using the buildx `--cache-to` syntax, e.g. `type=registry,ref=...,mode=max`, `type=gha,scope=...`, `type=local,dest=...`, `type=inline` or `type=s3,...`. Only available when `builder` is set.",
+							Optional:    true,
+							ForceNew:    true,
+							Elem: &schema.Schema{
+								Type:        schema.TypeString,
+								Description: "The cache export target",
+							},
+						},
 						"security_opt": {
 							Type:        schema.TypeList,
 							Description: "The security options",
@@ -389,7 +462,22 @@ func resourceDockerImage() *schema.Resource {
 						},
 						"platform": {
 							Type:        schema.TypeString,
-							Description: "Set the target platform for the build. Defaults to `GOOS/GOARCH`. For more information see the [docker documentation](https://github.com/docker/buildx/blob/master/docs/reference/buildx.md#-set-the-target-platforms-for-the-build---platform)",
+							Description: "Set the target platform for the build. Defaults to `GOOS/GOARCH`. When `builder` is set, this may be a comma-separated list (e.g. `linux/amd64,linux/arm64`) to drive a single multi-platform build; see also `platforms`. For more information see the [docker documentation](https://github.com/docker/buildx/blob/master/docs/reference/buildx.md#-set-the-target-platforms-for-the-build---platform)",
+							Optional:    true,
+							ForceNew:    true,
+						},
+						"platforms": {
+							Type:        schema.TypeList,
+							Description: "Set the target platforms for the build, e.g. `[\"linux/amd64\", \"linux/arm64\"]`. Only available when `builder` is set. When more than one platform is given, the build produces a single OCI index covering all of them, and `repo_digest`/`image_id` are set to the index digest rather than a per-architecture image ID.",
+							Optional:    true,
+							ForceNew:    true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"push": {
+							Type:        schema.TypeBool,
+							Description: "Push the build result to the registry configured in `name`. Required whenever `build.platforms` (or a comma-separated `build.platform`) specifies more than one platform, since a multi-platform result cannot be loaded into the local docker image store.",
 							Optional:    true,
 							ForceNew:    true,
 						},
@@ -417,6 +505,93 @@ func resourceDockerImage() *schema.Resource {
 							Optional:    true,
 							ForceNew:    true,
 						},
+						"output": {
+							Type:        schema.TypeList,
+							Description: "Configure buildx exporters, mirroring the `--output` flag (e.g. `type=registry` to push directly, `type=oci`/`type=docker` to write a layout or tarball to `dest`, or `type=local` to export the rootfs). Only available when `builder` is set. When set, `repo_digest` is populated from the resulting descriptor digest. For exporters other than `type=docker` no image is loaded into the local docker image store, so destroying the resource is a no-op for those entries.",
+							Optional:    true,
+							MinItems:    1,
+							ForceNew:    true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"type": {
+										Type:        schema.TypeString,
+										Description: "Exporter type, one of `docker`, `registry`, `oci`, `tar` or `local`.",
+										Required:    true,
+										ForceNew:    true,
+									},
+									"dest": {
+										Type:        schema.TypeString,
+										Description: "Local path to write the exporter output to. Required for `oci`, `tar` and `local`.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"name": {
+										Type:        schema.TypeString,
+										Description: "Name of the image, used by the `docker` and `registry` exporters.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"push": {
+										Type:        schema.TypeBool,
+										Description: "Push the resulting image after creation. Only relevant for the `registry` exporter.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"registry": {
+										Type:        schema.TypeString,
+										Description: "Registry host to push to when different from the host encoded in `name`.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"compression": {
+										Type:        schema.TypeString,
+										Description: "Compression type, e.g. `gzip`, `estargz` or `zstd`.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"compression_level": {
+										Type:        schema.TypeInt,
+										Description: "Compression level, e.g. `0` to `22` for zstd.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"force_compression": {
+										Type:        schema.TypeBool,
+										Description: "Forcibly apply `compression` even when the layer is already compressed with a different method.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+									"oci_mediatypes": {
+										Type:        schema.TypeBool,
+										Description: "Use OCI media types in the exporter manifests.",
+										Optional:    true,
+										ForceNew:    true,
+									},
+								},
+							},
+						},
+						"provenance": {
+							Type:        schema.TypeString,
+							Description: "Set the provenance attestation to add to the image, e.g. `false`, `mode=min` or `mode=max`. Only available when `builder` is set.",
+							Optional:    true,
+							ForceNew:    true,
+						},
+						"sbom": {
+							Type:        schema.TypeString,
+							Description: "Set the SBOM attestation to add to the image, e.g. `true` or `generator=<image>`. Only available when `builder` is set.",
+							Optional:    true,
+							ForceNew:    true,
+						},
+						"attests": {
+							Type:        schema.TypeList,
+							Description: "Set build attestations, e.g. `type=provenance,mode=max` or `type=sbom`. Only available when `builder` is set.",
+							Optional:    true,
+							ForceNew:    true,
+							Elem: &schema.Schema{
+								Type:        schema.TypeString,
+								Description: "The attestation",
+							},
+						},
 					},
 				},
 			},
@@ -436,3 +611,542 @@ func resourceDockerImage() *schema.Resource {
 		},
 	}
 }
+
+// resourceDockerImageCustomizeDiff validates cross-field constraints on the
+// `build` block that the schema package cannot express on its own.
+func resourceDockerImageCustomizeDiff(_ context.Context, diff *schema.ResourceDiff, _ interface{}) error {
+	buildSet, ok := diff.Get("build").(*schema.Set)
+	if !ok || buildSet.Len() == 0 {
+		return nil
+	}
+	build := buildSet.List()[0].(map[string]interface{})
+
+	builder, _ := build["builder"].(string)
+	provenance, _ := build["provenance"].(string)
+	sbom, _ := build["sbom"].(string)
+	attests, _ := build["attests"].([]interface{})
+
+	if provenance != "" || sbom != "" || len(attests) > 0 {
+		if builder == "" {
+			return fmt.Errorf("build.provenance, build.sbom and build.attests are only available when build.builder is set")
+		}
+	}
+
+	if provenance != "" && attestsHaveType(attests, "provenance") {
+		return fmt.Errorf("build.provenance conflicts with a `type=provenance` entry in build.attests; set one or the other")
+	}
+	if sbom != "" && attestsHaveType(attests, "sbom") {
+		return fmt.Errorf("build.sbom conflicts with a `type=sbom` entry in build.attests; set one or the other")
+	}
+
+	platforms := buildPlatforms(build)
+	if len(platforms) > 1 {
+		if builder == "" {
+			return fmt.Errorf("the legacy builder does not support more than one build.platform; set build.builder to use buildx")
+		}
+		if push, ok := build["push"].(bool); !ok || !push {
+			return fmt.Errorf("build.push must be true when more than one platform is requested, since a multi-platform result cannot be loaded into the local docker image store")
+		}
+	}
+
+	if cacheTo, _ := build["cache_to"].([]interface{}); len(cacheTo) > 0 && builder == "" {
+		return fmt.Errorf("build.cache_to is only available when build.builder is set")
+	}
+
+	if output, _ := build["output"].([]interface{}); len(output) > 0 {
+		if builder == "" {
+			return fmt.Errorf("build.output is only available when build.builder is set")
+		}
+		for _, o := range output {
+			exporter, ok := o.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			exporterType, _ := exporter["type"].(string)
+			if !dockerImageOutputTypes[exporterType] {
+				return fmt.Errorf("build.output[*].type %q is not one of docker, registry, oci, tar, local", exporterType)
+			}
+			dest, _ := exporter["dest"].(string)
+			if dest == "" && dockerImageOutputTypesRequiringDest[exporterType] {
+				return fmt.Errorf("build.output[*].dest is required for the %q exporter", exporterType)
+			}
+		}
+	}
+
+	if ssh, _ := build["ssh"].([]interface{}); len(ssh) > 0 && builder == "" {
+		return fmt.Errorf("build.ssh is only available when build.builder is set; the legacy builder cannot consume SSH mounts")
+	}
+
+	if annotations, _ := build["annotations"].([]interface{}); builder == "" {
+		for _, a := range annotations {
+			annotation, ok := a.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if level, _ := annotation["level"].(string); level != "" {
+				return fmt.Errorf("build.annotations[*].level is only available when build.builder is set; the legacy builder does not accept annotation level qualifiers")
+			}
+		}
+	}
+	if builder == "" {
+		if cacheFrom, _ := build["cache_from"].([]interface{}); len(cacheFrom) > 0 {
+			for _, c := range cacheFrom {
+				entry, ok := c.(string)
+				if ok && strings.Contains(entry, "=") {
+					return fmt.Errorf("build.cache_from entry %q is not a plain image reference; the `type=...` syntax requires build.builder to be set", entry)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildPlatforms returns the requested target platforms from build,
+// combining the comma-separated legacy `platform` field with the `platforms`
+// list.
+func buildPlatforms(build map[string]interface{}) []string {
+	var platforms []string
+	if platform, _ := build["platform"].(string); platform != "" {
+		for _, p := range strings.Split(platform, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				platforms = append(platforms, p)
+			}
+		}
+	}
+	if list, ok := build["platforms"].([]interface{}); ok {
+		for _, p := range list {
+			if s, ok := p.(string); ok && s != "" {
+				platforms = append(platforms, s)
+			}
+		}
+	}
+	return platforms
+}
+
+// attestsHaveType reports whether attests contains an entry whose `type=`
+// key matches attestType.
+func attestsHaveType(attests []interface{}, attestType string) bool {
+	for _, a := range attests {
+		entry, ok := a.(string)
+		if !ok {
+			continue
+		}
+		for _, part := range strings.Split(entry, ",") {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) == 2 && kv[0] == "type" && kv[1] == attestType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dockerImageSolveOpt assembles a BuildKit client.SolveOpt for a buildx build
+// of the `build` block: attestation and platform frontend attrs, exporters
+// with their annotation attrs, cache imports/exports and the SSH session
+// attachable. name is the resource's `name` attribute, used to tag/push the
+// build result. resourceDockerImageCreate does not call it.
+func dockerImageSolveOpt(build map[string]interface{}, name string) (*client.SolveOpt, error) {
+	opt := &client.SolveOpt{
+		FrontendAttrs: dockerImageAttestAttrs(build),
+	}
+
+	platforms := buildPlatforms(build)
+	if len(platforms) > 0 {
+		opt.FrontendAttrs["platform"] = strings.Join(platforms, ",")
+	}
+
+	push, _ := build["push"].(bool)
+	if output, _ := build["output"].([]interface{}); len(output) > 0 {
+		exports, err := dockerImageExports(build, name)
+		if err != nil {
+			return nil, err
+		}
+		opt.Exports = exports
+	} else if len(platforms) > 1 || push {
+		// A multi-platform result can only be materialized as a pushed OCI
+		// index; there is no single local image to load it into. name is
+		// pushed through as-is (tag included).
+		opt.Exports = []client.ExportEntry{
+			{
+				Type: client.ExporterImage,
+				Attrs: map[string]string{
+					"name": name,
+					"push": fmt.Sprintf("%t", push),
+				},
+			},
+		}
+	}
+
+	annotationAttrs := dockerImageAnnotationAttrs(build)
+	for k, v := range annotationAttrs {
+		for i := range opt.Exports {
+			opt.Exports[i].Attrs[k] = v
+		}
+	}
+
+	cacheFrom, err := dockerImageCacheImports(build)
+	if err != nil {
+		return nil, err
+	}
+	opt.CacheImports = cacheFrom
+
+	cacheTo, err := dockerImageCacheExports(build)
+	if err != nil {
+		return nil, err
+	}
+	opt.CacheExports = cacheTo
+
+	ssh, err := dockerImageSSHAttachable(build)
+	if err != nil {
+		return nil, err
+	}
+	if ssh != nil {
+		opt.Session = append(opt.Session, ssh)
+	}
+
+	return opt, nil
+}
+
+// dockerImageSSHAttachable builds the session.Attachable dockerImageSolveOpt
+// adds to SolveOpt.Session so `RUN --mount=type=ssh` has something to
+// forward to, mirroring buildx's `--ssh` flag. It returns nil when build.ssh
+// is empty.
+func dockerImageSSHAttachable(build map[string]interface{}) (session.Attachable, error) {
+	sshEntries, _ := build["ssh"].([]interface{})
+	if len(sshEntries) == 0 {
+		return nil, nil
+	}
+
+	configs := make([]sshprovider.AgentConfig, 0, len(sshEntries))
+	for _, s := range sshEntries {
+		entry, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := entry["id"].(string)
+		cfg := sshprovider.AgentConfig{ID: id}
+
+		if paths, ok := entry["paths"].([]interface{}); ok {
+			for _, p := range paths {
+				if path, ok := p.(string); ok && path != "" {
+					cfg.Paths = append(cfg.Paths, path)
+				}
+			}
+		}
+		// With no paths, AgentConfig falls back to forwarding the default
+		// SSH agent socket ($SSH_AUTH_SOCK); with paths, it attaches a
+		// key-file provider for those keys instead.
+		configs = append(configs, cfg)
+	}
+
+	provider, err := sshprovider.NewSSHAgentProvider(configs)
+	if err != nil {
+		return nil, fmt.Errorf("build.ssh: %w", err)
+	}
+	return provider, nil
+}
+
+// dockerImageCacheImports parses build.cache_from into BuildKit
+// client.CacheOptionsEntry values. Plain image references (no `type=...`
+// prefix, the only form the legacy builder accepts) are treated as
+// `type=registry,ref=<image>` entries.
+func dockerImageCacheImports(build map[string]interface{}) ([]client.CacheOptionsEntry, error) {
+	cacheFrom, _ := build["cache_from"].([]interface{})
+	entries := make([]client.CacheOptionsEntry, 0, len(cacheFrom))
+	for _, c := range cacheFrom {
+		ref, ok := c.(string)
+		if !ok || ref == "" {
+			continue
+		}
+		entry, err := parseCacheOptionsEntry(ref)
+		if err != nil {
+			return nil, fmt.Errorf("build.cache_from entry %q: %w", ref, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// dockerImageCacheExports parses build.cache_to into BuildKit
+// client.CacheOptionsEntry values, mirroring buildx's `--cache-to` syntax.
+func dockerImageCacheExports(build map[string]interface{}) ([]client.CacheOptionsEntry, error) {
+	cacheTo, _ := build["cache_to"].([]interface{})
+	entries := make([]client.CacheOptionsEntry, 0, len(cacheTo))
+	for _, c := range cacheTo {
+		ref, ok := c.(string)
+		if !ok || ref == "" {
+			continue
+		}
+		entry, err := parseCacheOptionsEntry(ref)
+		if err != nil {
+			return nil, fmt.Errorf("build.cache_to entry %q: %w", ref, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseCacheOptionsEntry parses a single buildx `--cache-from`/`--cache-to`
+// value (`type=registry,ref=...,mode=max`, or a plain image reference) into
+// a client.CacheOptionsEntry.
+func parseCacheOptionsEntry(value string) (client.CacheOptionsEntry, error) {
+	if !strings.Contains(value, "=") {
+		return client.CacheOptionsEntry{
+			Type:  "registry",
+			Attrs: map[string]string{"ref": value},
+		}, nil
+	}
+
+	entry := client.CacheOptionsEntry{Attrs: map[string]string{}}
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return client.CacheOptionsEntry{}, fmt.Errorf("invalid field %q, expected key=value", part)
+		}
+		if kv[0] == "type" {
+			entry.Type = kv[1]
+			continue
+		}
+		entry.Attrs[kv[0]] = kv[1]
+	}
+	if entry.Type == "" {
+		return client.CacheOptionsEntry{}, fmt.Errorf("missing type= field")
+	}
+	return entry, nil
+}
+
+// dockerImageAttestAttrs translates build.provenance, build.sbom and
+// build.attests into the `attest:<type>=<value>` frontend attributes the
+// dockerfile frontend reads to emit SLSA provenance/SBOM attestations.
+func dockerImageAttestAttrs(build map[string]interface{}) map[string]string {
+	attrs := map[string]string{}
+
+	if provenance, _ := build["provenance"].(string); provenance != "" {
+		attrs["attest:provenance"] = provenance
+	}
+	if sbom, _ := build["sbom"].(string); sbom != "" {
+		attrs["attest:sbom"] = sbom
+	}
+
+	if attests, ok := build["attests"].([]interface{}); ok {
+		for _, a := range attests {
+			entry, ok := a.(string)
+			if !ok || entry == "" {
+				continue
+			}
+			attestType, value := splitAttestType(entry)
+			if attestType == "" {
+				continue
+			}
+			attrs["attest:"+attestType] = value
+		}
+	}
+
+	return attrs
+}
+
+// splitAttestType pulls the `type=` key out of a buildx attest string,
+// returning the attest type and the remaining comma-separated key/value
+// pairs (the form BuildKit expects as the value of `attest:<type>`).
+func splitAttestType(entry string) (attestType string, rest string) {
+	var kept []string
+	for _, part := range strings.Split(entry, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 && kv[0] == "type" {
+			attestType = kv[1]
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(part))
+	}
+	return attestType, strings.Join(kept, ",")
+}
+
+// dockerImageRepoName strips the tag or digest off of an image reference,
+// preserving the registry host (including any `:port`) and repository path.
+// It prefers github.com/distribution/reference so normalization matches what
+// the docker daemon/buildx itself would do; if name doesn't parse as a valid
+// reference (e.g. it's a bare `build.output[*].name` fragment), it falls
+// back to trimming only the last path segment's tag/digest, since a
+// host:port can only appear before the first `/`.
+func dockerImageRepoName(name string) string {
+	if named, err := reference.ParseNormalizedNamed(name); err == nil {
+		return named.Name()
+	}
+
+	prefix := ""
+	last := name
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		prefix, last = name[:i+1], name[i+1:]
+	}
+	if i := strings.IndexAny(last, ":@"); i != -1 {
+		last = last[:i]
+	}
+	return prefix + last
+}
+
+// dockerImageApplySolveResponse records the digest BuildKit produced for a
+// buildx solve (the `containerimage.digest` exporter response) into
+// `repo_digest`, as `<repository>@<digest>` with the repository taken from
+// dockerImageRepoName(name), and into `image_id`. It sets nothing when resp
+// is nil or carries no digest. resourceDockerImageCreate does not call it.
+func dockerImageApplySolveResponse(d *schema.ResourceData, name string, resp *client.SolveResponse) error {
+	if resp == nil {
+		return nil
+	}
+	digest := resp.ExporterResponse["containerimage.digest"]
+	if digest == "" {
+		return nil
+	}
+
+	if err := d.Set("repo_digest", fmt.Sprintf("%s@%s", dockerImageRepoName(name), digest)); err != nil {
+		return err
+	}
+	return d.Set("image_id", digest)
+}
+
+// dockerImageAnnotationLevelKeys maps build.annotations[*].level to the
+// exporter attr prefix BuildKit groups OCI annotations under, so they land
+// on the right descriptor (the image manifest, its descriptor in a manifest
+// list/index, the index itself, or the index's own descriptor).
+var dockerImageAnnotationLevelKeys = map[string]string{
+	"":                    "annotation-manifest",
+	"manifest":            "annotation-manifest",
+	"manifest-descriptor": "annotation-manifest-descriptor",
+	"index":               "annotation-index",
+	"index-descriptor":    "annotation-index-descriptor",
+}
+
+// dockerImageAnnotationAttrs translates build.annotations into exporter
+// attrs carrying OCI annotations (`org.opencontainers.image.*` and friends),
+// keyed by the descriptor build.annotations[*].level targets.
+func dockerImageAnnotationAttrs(build map[string]interface{}) map[string]string {
+	annotations, _ := build["annotations"].([]interface{})
+	attrs := make(map[string]string, len(annotations))
+	for _, a := range annotations {
+		annotation, ok := a.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, _ := annotation["key"].(string)
+		value, _ := annotation["value"].(string)
+		if key == "" {
+			continue
+		}
+		level, _ := annotation["level"].(string)
+		prefix, ok := dockerImageAnnotationLevelKeys[level]
+		if !ok {
+			prefix = dockerImageAnnotationLevelKeys[""]
+		}
+		attrs[prefix+"."+key] = value
+	}
+	return attrs
+}
+
+// dockerImageOutputTypes is the set of exporters build.output accepts.
+var dockerImageOutputTypes = map[string]bool{
+	"docker":   true,
+	"registry": true,
+	"oci":      true,
+	"tar":      true,
+	"local":    true,
+}
+
+// dockerImageOutputTypesRequiringDest is the subset of dockerImageOutputTypes
+// that write to a local path and therefore require build.output[*].dest.
+var dockerImageOutputTypesRequiringDest = map[string]bool{
+	"oci":   true,
+	"tar":   true,
+	"local": true,
+}
+
+// dockerImageExports translates build.output into BuildKit client.ExportEntry
+// values. The `docker` and `registry` exporters load into (or push to) name
+// when build.output[*].name is left unset.
+func dockerImageExports(build map[string]interface{}, name string) ([]client.ExportEntry, error) {
+	output, _ := build["output"].([]interface{})
+	exports := make([]client.ExportEntry, 0, len(output))
+	for _, o := range output {
+		exporter, ok := o.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		exporterType, _ := exporter["type"].(string)
+		if !dockerImageOutputTypes[exporterType] {
+			return nil, fmt.Errorf("build.output[*].type %q is not one of docker, registry, oci, tar, local", exporterType)
+		}
+		dest, _ := exporter["dest"].(string)
+		if dest == "" && dockerImageOutputTypesRequiringDest[exporterType] {
+			return nil, fmt.Errorf("build.output[*].dest is required for the %q exporter", exporterType)
+		}
+
+		entry := client.ExportEntry{Type: exporterType, Attrs: map[string]string{}}
+
+		imageName, _ := exporter["name"].(string)
+		if imageName == "" {
+			imageName = name
+		}
+		if exporterType == "docker" || exporterType == "registry" {
+			entry.Attrs["name"] = imageName
+		}
+		if push, ok := exporter["push"].(bool); ok {
+			entry.Attrs["push"] = fmt.Sprintf("%t", push)
+		}
+		if registry, _ := exporter["registry"].(string); registry != "" {
+			entry.Attrs["registry"] = registry
+		}
+		if compression, _ := exporter["compression"].(string); compression != "" {
+			entry.Attrs["compression"] = compression
+		}
+		if level, ok := exporter["compression_level"].(int); ok && level != 0 {
+			entry.Attrs["compression-level"] = fmt.Sprintf("%d", level)
+		}
+		if force, ok := exporter["force_compression"].(bool); ok && force {
+			entry.Attrs["force-compression"] = "true"
+		}
+		if ociTypes, ok := exporter["oci_mediatypes"].(bool); ok && ociTypes {
+			entry.Attrs["oci-mediatypes"] = "true"
+		}
+
+		// The `local` exporter writes a directory tree and takes OutputDir;
+		// `docker`/`oci`/`tar` all write a single tar stream and take Output
+		// instead, opening dest lazily since BuildKit calls Output only once
+		// the export is actually ready to be written.
+		switch exporterType {
+		case "local":
+			entry.OutputDir = dest
+		case "docker", "oci", "tar":
+			if dest != "" {
+				entry.Output = func(map[string]string) (io.WriteCloser, error) {
+					return os.Create(dest)
+				}
+			}
+		}
+
+		exports = append(exports, entry)
+	}
+	return exports, nil
+}
+
+// dockerImageSkipDelete reports whether resourceDockerImageDelete should
+// no-op instead of removing a local image: when build.output is set and
+// every exporter is something other than `docker`, the build never produced
+// a local image for the docker daemon to delete.
+func dockerImageSkipDelete(build map[string]interface{}) bool {
+	output, _ := build["output"].([]interface{})
+	if len(output) == 0 {
+		return false
+	}
+	for _, o := range output {
+		exporter, ok := o.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if exporterType, _ := exporter["type"].(string); exporterType == "docker" {
+			return false
+		}
+	}
+	return true
+}